@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// RotateAccountKey performs an RFC 8555 section 7.3.5 account key rollover:
+// it signs the inner {account, oldKey} payload with newKey, wraps it in an
+// outer JWS signed by the account's current key, POSTs the result to the
+// directory's keyChange endpoint, and, once the CA confirms the rollover,
+// atomically swaps the key held in the client's store so subsequent
+// requests sign with newKey.
+func (c *Client) RotateAccountKey(newKey *ecdsa.PrivateKey) error {
+	oldJWK, err := jwkFromKey(&c.key.PublicKey)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode the current account key")
+	}
+
+	innerPayload, err := json.Marshal(struct {
+		Account string          `json:"account"`
+		OldKey  json.RawMessage `json:"oldKey"`
+	}{
+		Account: c.accountURL,
+		OldKey:  oldJWK,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the key rollover payload")
+	}
+
+	// The inner JWS is signed by the new key over {account, oldKey}; the
+	// outer JWS (built by c.signedRequest) is signed by the current
+	// account key, per the "innerJWS" requirement in RFC 8555 7.3.5.
+	innerJWS, err := signJWS(newKey, c.directory.KeyChange, innerPayload, "")
+	if err != nil {
+		return errors.Wrap(err, "failed to sign the inner key rollover JWS")
+	}
+
+	if err := c.post(c.directory.KeyChange, innerJWS, nil); err != nil {
+		return errors.Wrap(err, "failed to submit the key rollover request")
+	}
+
+	if err := c.store.SwapAccountKey(newKey); err != nil {
+		return errors.Wrap(err, "failed to persist the rotated account key")
+	}
+
+	c.key = newKey
+
+	return nil
+}
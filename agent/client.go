@@ -0,0 +1,239 @@
+package agent
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"crypto/ecdsa"
+
+	"github.com/pkg/errors"
+)
+
+// Directory mirrors the subset of an ACME directory object (RFC 8555
+// section 7.1.1) the client needs.
+type Directory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+	KeyChange  string `json:"keyChange"`
+}
+
+// Client is an ACME client bound to a single account key, persisted
+// through store.
+type Client struct {
+	directoryURL string
+	store        *Store
+	httpClient   *http.Client
+
+	directory  *Directory
+	key        *ecdsa.PrivateKey
+	accountURL string
+	nonce      string
+
+	// eabKID and eabHMACKey configure External Account Binding; see
+	// WithExternalAccountBinding.
+	eabKID     string
+	eabHMACKey []byte
+}
+
+// NewClient creates a Client for directoryURL, persisting its account state
+// through store.
+func NewClient(directoryURL string, store *Store, opts ...ClientOption) *Client {
+	c := &Client{
+		directoryURL: directoryURL,
+		store:        store,
+		httpClient:   http.DefaultClient,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Init fetches the ACME directory, loads the account key from the store and
+// registers the account with the CA, agreeing to the terms of service. When
+// the client was configured with WithExternalAccountBinding, the account is
+// bound to the external key by embedding a flattened JWS, built by
+// buildExternalAccountBinding, as the newAccount request's
+// externalAccountBinding member (RFC 8555 section 7.3.4) -- without it, CAs
+// that require EAB reject the request outright.
+func (c *Client) Init() error {
+	if err := c.fetchDirectory(); err != nil {
+		return err
+	}
+
+	key, err := c.store.LoadPrivateKey()
+	if err != nil {
+		return errors.Wrap(err, "failed to load the account key")
+	}
+	c.key = key
+
+	req := struct {
+		TermsOfServiceAgreed   bool            `json:"termsOfServiceAgreed"`
+		ExternalAccountBinding json.RawMessage `json:"externalAccountBinding,omitempty"`
+	}{TermsOfServiceAgreed: true}
+
+	if c.eabKID != "" {
+		accountJWK, err := jwkFromKey(&c.key.PublicKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode the account key")
+		}
+
+		eab, err := buildExternalAccountBinding(accountJWK, c.directory.NewAccount, c.eabKID, c.eabHMACKey)
+		if err != nil {
+			return errors.Wrap(err, "failed to build the external account binding")
+		}
+		req.ExternalAccountBinding = eab
+	}
+
+	resp, err := c.signedRequest(c.directory.NewAccount, req)
+	if err != nil {
+		return errors.Wrap(err, "failed to create the ACME account")
+	}
+	defer resp.Body.Close()
+
+	accountURL := resp.Header.Get("Location")
+	if accountURL == "" {
+		return errors.New("aaa: server did not return an account URL")
+	}
+	c.accountURL = accountURL
+
+	return nil
+}
+
+// post sends a signed POST request to url with payload as the JWS payload,
+// decoding the JSON response body into out (which may be nil if the
+// response body is not needed).
+func (c *Client) post(url string, payload interface{}, out interface{}) error {
+	resp, err := c.signedRequest(url, payload)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+
+	return decodeJSONBody(resp, out)
+}
+
+// signedRequest signs payload as a flattened JWS -- keyed by the account
+// (kid) once Init has run, or by the account key's own JWK beforehand, as
+// newAccount requires -- and POSTs it to url, refreshing the client's
+// replay nonce from the response.
+func (c *Client) signedRequest(url string, payload interface{}) (*http.Response, error) {
+	payloadJSON, err := marshalPayload(payload)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the request payload")
+	}
+
+	if c.nonce == "" {
+		nonce, err := c.fetchNonce()
+		if err != nil {
+			return nil, err
+		}
+		c.nonce = nonce
+	}
+
+	jws, err := signJWS(c.key, url, payloadJSON, c.accountURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign the request")
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewReader(jws))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build the request")
+	}
+	req.Header.Set("Content-Type", "application/jose+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to send the request")
+	}
+
+	if nonce := resp.Header.Get("Replay-Nonce"); nonce != "" {
+		c.nonce = nonce
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+		return nil, decodeACMEError(resp)
+	}
+
+	return resp, nil
+}
+
+// marshalPayload encodes payload as the raw JSON to be signed. A
+// json.RawMessage is passed through unchanged, since account key rollover
+// signs an already-encoded inner JWS as its payload.
+func marshalPayload(payload interface{}) ([]byte, error) {
+	if raw, ok := payload.(json.RawMessage); ok {
+		return raw, nil
+	}
+
+	return json.Marshal(payload)
+}
+
+// decodeJSONBody decodes resp's JSON body into out.
+func decodeJSONBody(resp *http.Response, out interface{}) error {
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// decodeACMEError decodes an RFC 7807 problem document returned by the CA
+// into an error.
+func decodeACMEError(resp *http.Response) error {
+	var problem struct {
+		Type   string `json:"type"`
+		Detail string `json:"detail"`
+	}
+
+	if err := decodeJSONBody(resp, &problem); err != nil {
+		return errors.Errorf("aaa: request failed with status %s", resp.Status)
+	}
+
+	return errors.Errorf("aaa: request failed with status %s: %s (%s)", resp.Status, problem.Detail, problem.Type)
+}
+
+// fetchDirectory populates c.directory from c.directoryURL if it hasn't
+// been fetched yet.
+func (c *Client) fetchDirectory() error {
+	if c.directory != nil {
+		return nil
+	}
+
+	resp, err := c.httpClient.Get(c.directoryURL)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch the ACME directory")
+	}
+	defer resp.Body.Close()
+
+	var dir Directory
+	if err := decodeJSONBody(resp, &dir); err != nil {
+		return errors.Wrap(err, "failed to decode the ACME directory")
+	}
+
+	c.directory = &dir
+
+	return nil
+}
+
+// fetchNonce fetches a fresh replay nonce from the directory's newNonce
+// endpoint.
+func (c *Client) fetchNonce() (string, error) {
+	resp, err := c.httpClient.Head(c.directory.NewNonce)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to fetch a replay nonce")
+	}
+	defer resp.Body.Close()
+
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("aaa: server did not return a Replay-Nonce")
+	}
+
+	return nonce, nil
+}
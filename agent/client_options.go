@@ -0,0 +1,18 @@
+package agent
+
+// ClientOption configures optional behavior on a Client created via
+// NewClient.
+type ClientOption func(*Client)
+
+// WithExternalAccountBinding configures the Client to bind its ACME account
+// to an externally issued key (kid, hmacKey) the next time Init creates a
+// new account. This is required by CAs that only issue accounts under
+// External Account Binding, such as ZeroSSL, Google Trust Services and most
+// enterprise step-ca deployments. hmacKey is the base64url-decoded MAC key
+// the CA issued alongside kid; see DecodeEABHMACKey.
+func WithExternalAccountBinding(kid string, hmacKey []byte) ClientOption {
+	return func(c *Client) {
+		c.eabKID = kid
+		c.eabHMACKey = hmacKey
+	}
+}
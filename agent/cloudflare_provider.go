@@ -0,0 +1,101 @@
+package agent
+
+import (
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflare-go"
+	"github.com/pkg/errors"
+)
+
+// CloudflareProvider implements DNSProvider on top of the Cloudflare API,
+// authenticating with an API token.
+type CloudflareProvider struct {
+	api *cloudflare.API
+	ttl int
+}
+
+// NewCloudflareProvider creates a CloudflareProvider authenticated with
+// apiToken. ttl is the TTL, in seconds, used for records it creates; pass 0
+// for Cloudflare's "automatic" TTL, which is translated to Cloudflare's own
+// automatic sentinel of 1 -- 0 is below the API's valid TTL range and would
+// have CreateDNSRecord reject every request.
+func NewCloudflareProvider(apiToken string, ttl int) (*CloudflareProvider, error) {
+	api, err := cloudflare.NewWithAPIToken(apiToken)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to initialize the Cloudflare API client")
+	}
+
+	if ttl == 0 {
+		ttl = 1
+	}
+
+	return &CloudflareProvider{api: api, ttl: ttl}, nil
+}
+
+func (p *CloudflareProvider) zoneIDForFQDN(fqdn string) (string, error) {
+	zoneName := strings.TrimSuffix(fqdn, ".")
+
+	for {
+		idx := strings.Index(zoneName, ".")
+		if idx == -1 {
+			break
+		}
+
+		id, err := p.api.ZoneIDByName(zoneName)
+		if err == nil {
+			return id, nil
+		}
+
+		zoneName = zoneName[idx+1:]
+	}
+
+	return "", errors.Errorf("aaa: no Cloudflare zone found for %s", fqdn)
+}
+
+// Present creates the TXT record at fqdn in the Cloudflare zone that owns
+// it.
+func (p *CloudflareProvider) Present(fqdn, value string) error {
+	zoneID, err := p.zoneIDForFQDN(fqdn)
+	if err != nil {
+		return err
+	}
+
+	_, err = p.api.CreateDNSRecord(zoneID, cloudflare.DNSRecord{
+		Type:    "TXT",
+		Name:    strings.TrimSuffix(fqdn, "."),
+		Content: value,
+		TTL:     p.ttl,
+	})
+	return errors.Wrap(err, "failed to create the Cloudflare TXT record")
+}
+
+// CleanUp removes the TXT record created by Present.
+func (p *CloudflareProvider) CleanUp(fqdn, value string) error {
+	zoneID, err := p.zoneIDForFQDN(fqdn)
+	if err != nil {
+		return err
+	}
+
+	records, err := p.api.DNSRecords(zoneID, cloudflare.DNSRecord{
+		Type:    "TXT",
+		Name:    strings.TrimSuffix(fqdn, "."),
+		Content: value,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to look up the Cloudflare TXT record")
+	}
+
+	for _, r := range records {
+		if err := p.api.DeleteDNSRecord(zoneID, r.ID); err != nil {
+			return errors.Wrap(err, "failed to delete the Cloudflare TXT record")
+		}
+	}
+
+	return nil
+}
+
+// Timeout returns how long to wait for Cloudflare's DNS to propagate.
+func (p *CloudflareProvider) Timeout() (time.Duration, time.Duration) {
+	return 2 * time.Minute, 5 * time.Second
+}
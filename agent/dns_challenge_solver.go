@@ -0,0 +1,82 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DNSChallengeSolver solves the "dns-01" challenge by publishing a TXT
+// record through a DNSProvider and waiting for it to propagate.
+type DNSChallengeSolver struct {
+	provider DNSProvider
+	domain   string
+}
+
+// NewDNSChallengeSolver creates a DNSChallengeSolver for domain, publishing
+// the TXT record through provider. provider may be backed by Route53,
+// Cloudflare, RFC 2136 dynamic updates, or anything else implementing
+// DNSProvider.
+func NewDNSChallengeSolver(provider DNSProvider, domain string) *DNSChallengeSolver {
+	return &DNSChallengeSolver{
+		provider: provider,
+		domain:   domain,
+	}
+}
+
+func (s *DNSChallengeSolver) fqdn() string {
+	return "_acme-challenge." + s.domain + "."
+}
+
+// dnsTokenValue computes the TXT record value for keyAuthz per RFC 8555
+// section 8.4: base64url(sha256(keyAuthz)), without padding.
+func dnsTokenValue(keyAuthz string) string {
+	digest := sha256.Sum256([]byte(keyAuthz))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+// SolveChallenge publishes the TXT record derived from keyAuthz and blocks
+// until it can be resolved, so the CA doesn't see a validation attempt
+// against a record that hasn't propagated yet.
+func (s *DNSChallengeSolver) SolveChallenge(keyAuthz string) error {
+	value := dnsTokenValue(keyAuthz)
+
+	if err := s.provider.Present(s.fqdn(), value); err != nil {
+		return err
+	}
+
+	total, interval := s.provider.Timeout()
+
+	return waitForTXTRecord(s.fqdn(), value, total, interval)
+}
+
+// waitForTXTRecord polls fqdn for a TXT record equal to value, retrying
+// every interval until it appears or total has elapsed.
+func waitForTXTRecord(fqdn, value string, total, interval time.Duration) error {
+	deadline := time.Now().Add(total)
+
+	for {
+		records, err := net.LookupTXT(fqdn)
+		if err == nil {
+			for _, record := range records {
+				if record == value {
+					return nil
+				}
+			}
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("aaa: TXT record %s did not propagate within %s", fqdn, total)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// CleanupChallenge removes the TXT record published by SolveChallenge.
+func (s *DNSChallengeSolver) CleanupChallenge(keyAuthz string) error {
+	return s.provider.CleanUp(s.fqdn(), dnsTokenValue(keyAuthz))
+}
@@ -0,0 +1,18 @@
+package agent
+
+import "time"
+
+// DNSProvider publishes and retracts the TXT record used by the dns-01
+// challenge. Implementations are passed to NewDNSChallengeSolver so that
+// domains whose apex zone is not hosted on Route53 can still use dns-01.
+type DNSProvider interface {
+	// Present publishes a TXT record at fqdn with the given value.
+	Present(fqdn, value string) error
+
+	// CleanUp removes the TXT record previously published by Present.
+	CleanUp(fqdn, value string) error
+
+	// Timeout returns how long, and how often, the solver should wait for
+	// the record to propagate before giving up.
+	Timeout() (total, interval time.Duration)
+}
@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// buildExternalAccountBinding builds the flattened JWS embedded as the
+// "externalAccountBinding" field of a newAccount request, per RFC 8555
+// section 7.3.4. accountJWK is the JSON Web Key of the account key being
+// bound to the externally issued kid; hmacKey is the base64url-decoded MAC
+// key the CA handed out alongside kid.
+func buildExternalAccountBinding(accountJWK json.RawMessage, newAccountURL, kid string, hmacKey []byte) (json.RawMessage, error) {
+	header := struct {
+		Alg string `json:"alg"`
+		KID string `json:"kid"`
+		URL string `json:"url"`
+	}{Alg: "HS256", KID: kid, URL: newAccountURL}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the EAB protected header")
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+	payload := base64.RawURLEncoding.EncodeToString(accountJWK)
+
+	mac := hmac.New(sha256.New, hmacKey)
+	mac.Write([]byte(protected + "." + payload))
+	signature := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+
+	eab := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{Protected: protected, Payload: payload, Signature: signature}
+
+	return json.Marshal(eab)
+}
+
+// DecodeEABHMACKey decodes the base64url-encoded HMAC key a CA hands out
+// alongside an EAB key ID, as passed via --eab-hmac-key.
+func DecodeEABHMACKey(encoded string) ([]byte, error) {
+	key, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the EAB HMAC key")
+	}
+	return key, nil
+}
@@ -0,0 +1,13 @@
+package agent
+
+// FindHTTPChallenge looks for an "http-01" combination in authz and returns
+// the associated Challenge.
+func FindHTTPChallenge(authz *AuthorizationResponse) (Challenge, bool) {
+	for _, c := range authz.Challenges {
+		if c.Type == "http-01" {
+			return c, true
+		}
+	}
+
+	return Challenge{}, false
+}
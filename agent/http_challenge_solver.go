@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"path"
+
+	"github.com/nabeken/aws-go-s3/bucket"
+)
+
+// HTTPChallengeSolver solves the "http-01" challenge by publishing the key
+// authorization to S3 under /.well-known/acme-challenge/<token> with a
+// public-read ACL. It is intended to be paired with an S3 static website (or
+// a CloudFront distribution backed by the bucket) so that no HTTP server
+// needs to run to answer the challenge request:
+//
+//	S3 bucket (static website or CloudFront origin)
+//	  └── <prefix>/.well-known/acme-challenge/<token>
+//
+// Point CloudFront/S3 website routing at the bucket and path prefix used
+// here, and the CA will be able to fetch the challenge response directly.
+type HTTPChallengeSolver struct {
+	bucket *bucket.Bucket
+	prefix string
+	token  string
+}
+
+// NewHTTPChallengeSolver creates an HTTPChallengeSolver that publishes to b
+// under prefix.
+func NewHTTPChallengeSolver(b *bucket.Bucket, prefix string, challenge Challenge) *HTTPChallengeSolver {
+	return &HTTPChallengeSolver{
+		bucket: b,
+		prefix: prefix,
+		token:  challenge.Token,
+	}
+}
+
+func (s *HTTPChallengeSolver) key() string {
+	return path.Join(s.prefix, ".well-known", "acme-challenge", s.token)
+}
+
+// SolveChallenge publishes keyAuthz to the well-known challenge path with a
+// public-read ACL so the CA can fetch it over plain HTTP.
+func (s *HTTPChallengeSolver) SolveChallenge(keyAuthz string) error {
+	_, err := s.bucket.PutObject(s.key(), []byte(keyAuthz), "public-read", "")
+	return err
+}
+
+// CleanupChallenge removes the published challenge response.
+func (s *HTTPChallengeSolver) CleanupChallenge(keyAuthz string) error {
+	_, err := s.bucket.DeleteObject(s.key())
+	return err
+}
@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// jwk is the JSON Web Key encoding of an ECDSA P-256 public key, the only
+// key type aaa issues ACME accounts with.
+type jwk struct {
+	KTY string `json:"kty"`
+	CRV string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// jwkFromKey encodes pub as a JSON Web Key.
+func jwkFromKey(pub *ecdsa.PublicKey) (json.RawMessage, error) {
+	size := (pub.Curve.Params().BitSize + 7) / 8
+
+	return json.Marshal(jwk{
+		KTY: "EC",
+		CRV: "P-256",
+		X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+		Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+	})
+}
+
+// signJWS builds a flattened JWS over payload, signed by key, with a
+// protected header carrying url. When kid is empty the header embeds the
+// signer's own JWK instead, as newAccount and the key-rollover inner JWS
+// require.
+func signJWS(key *ecdsa.PrivateKey, url string, payload json.RawMessage, kid string) (json.RawMessage, error) {
+	header := struct {
+		Alg string          `json:"alg"`
+		JWK json.RawMessage `json:"jwk,omitempty"`
+		KID string          `json:"kid,omitempty"`
+		URL string          `json:"url"`
+	}{Alg: "ES256", URL: url}
+
+	if kid != "" {
+		header.KID = kid
+	} else {
+		pubJWK, err := jwkFromKey(&key.PublicKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to encode the signer's JWK")
+		}
+		header.JWK = pubJWK
+	}
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal the JWS protected header")
+	}
+
+	protected := base64.RawURLEncoding.EncodeToString(headerJSON)
+	encodedPayload := base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(protected + "." + encodedPayload))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to sign the JWS")
+	}
+
+	size := (key.Curve.Params().BitSize + 7) / 8
+	sig := append(r.FillBytes(make([]byte, size)), s.FillBytes(make([]byte, size))...)
+
+	jws := struct {
+		Protected string `json:"protected"`
+		Payload   string `json:"payload"`
+		Signature string `json:"signature"`
+	}{
+		Protected: protected,
+		Payload:   encodedPayload,
+		Signature: base64.RawURLEncoding.EncodeToString(sig),
+	}
+
+	return json.Marshal(jws)
+}
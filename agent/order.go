@@ -0,0 +1,33 @@
+package agent
+
+import "github.com/pkg/errors"
+
+// NewOrderRequest is the payload for an ACME newOrder request. Sending every
+// SAN as a single Identifiers slice lets the CA authorize them under one
+// order instead of one order per domain.
+type NewOrderRequest struct {
+	Identifiers []*Identifier `json:"identifiers"`
+}
+
+// Order is an ACME order resource (RFC 8555 section 7.1.3).
+type Order struct {
+	Status string `json:"status"`
+
+	// Authorizations lists the per-identifier authorization URLs. RFC 8555
+	// does not guarantee they follow the order of the Identifiers sent in
+	// NewOrderRequest.
+	Authorizations []string `json:"authorizations"`
+
+	Finalize string `json:"finalize"`
+}
+
+// NewOrder creates an order authorizing every identifier in req in one
+// request, returning the authorization URLs to resolve individually.
+func (c *Client) NewOrder(req *NewOrderRequest) (*Order, error) {
+	var order Order
+	if err := c.post(c.directory.NewOrder, req, &order); err != nil {
+		return nil, errors.Wrap(err, "failed to create the order")
+	}
+
+	return &order, nil
+}
@@ -0,0 +1,13 @@
+package agent
+
+// FindTLSALPNChallenge looks for a "tls-alpn-01" combination in authz and
+// returns the associated Challenge.
+func FindTLSALPNChallenge(authz *AuthorizationResponse) (Challenge, bool) {
+	for _, c := range authz.Challenges {
+		if c.Type == "tls-alpn-01" {
+			return c, true
+		}
+	}
+
+	return Challenge{}, false
+}
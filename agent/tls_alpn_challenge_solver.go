@@ -0,0 +1,208 @@
+package agent
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// tlsALPNPropagationTimeout/Interval bound how long SolveChallenge waits for
+// the terminator in front of domain (ALB/NLB, sidecar, ...) to start serving
+// the certificate just uploaded, the tls-alpn-01 analog of
+// waitForTXTRecord's DNS propagation wait.
+const (
+	tlsALPNPropagationTimeout  = 2 * time.Minute
+	tlsALPNPropagationInterval = 5 * time.Second
+)
+
+// idPeACMEIdentifier is the id-pe-acmeIdentifier OID defined in RFC 8737
+// (1.3.6.1.5.5.7.1.31), used to bind a self-signed certificate to a
+// tls-alpn-01 key authorization.
+var idPeACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+// TLSALPNCertTarget publishes the self-signed certificate generated for a
+// tls-alpn-01 challenge somewhere the TLS terminator in front of the domain
+// will pick it up, and removes it once the challenge is done.
+type TLSALPNCertTarget interface {
+	Upload(domain string, certPEM, keyPEM []byte) error
+	Delete(domain string) error
+}
+
+// NewTLSALPNCertTarget builds a TLSALPNCertTarget for kind, one of "iam",
+// "acm" or "s3". name is the IAM server certificate name, the ACM ARN to
+// import into, or an "s3://bucket/prefix" location (the certificate and key
+// are written to <prefix>/<domain>.crt and .key) depending on kind.
+func NewTLSALPNCertTarget(kind, name string) (TLSALPNCertTarget, error) {
+	switch kind {
+	case "iam":
+		return newIAMServerCertTarget(name), nil
+	case "acm":
+		return newACMTarget(name), nil
+	case "s3":
+		return newS3CertTarget(name)
+	default:
+		return nil, fmt.Errorf("aaa: tls-alpn-01 target %s is not supported", kind)
+	}
+}
+
+// TLSALPNChallengeSolver solves the "tls-alpn-01" challenge by generating a
+// self-signed certificate carrying the id-pe-acmeIdentifier extension and
+// publishing it to target, where it can be served by a TLS terminator
+// (ALB/NLB, a sidecar, etc.) on port 443 for the duration of the challenge.
+type TLSALPNChallengeSolver struct {
+	target TLSALPNCertTarget
+	domain string
+	token  string
+}
+
+// NewTLSALPNChallengeSolver creates a TLSALPNChallengeSolver for domain,
+// publishing the generated certificate to target.
+func NewTLSALPNChallengeSolver(target TLSALPNCertTarget, domain string, challenge Challenge) *TLSALPNChallengeSolver {
+	return &TLSALPNChallengeSolver{
+		target: target,
+		domain: domain,
+		token:  challenge.Token,
+	}
+}
+
+// SolveChallenge builds the acmeIdentifier self-signed certificate for
+// keyAuthz, uploads it to the configured target, and blocks until the
+// terminator in front of s.domain is actually serving it, so the CA doesn't
+// see a validation attempt against a terminator that hasn't picked up the
+// new certificate yet.
+func (s *TLSALPNChallengeSolver) SolveChallenge(keyAuthz string) error {
+	certPEM, keyPEM, err := buildTLSALPNCertificate(s.domain, keyAuthz)
+	if err != nil {
+		return errors.Wrap(err, "failed to build the tls-alpn-01 certificate")
+	}
+
+	if err := s.target.Upload(s.domain, certPEM, keyPEM); err != nil {
+		return err
+	}
+
+	return waitForTLSALPNCert(s.domain, keyAuthz, tlsALPNPropagationTimeout, tlsALPNPropagationInterval)
+}
+
+// waitForTLSALPNCert polls domain over TLS, negotiating the acme-tls/1
+// protocol per RFC 8737 section 3, until the presented certificate's
+// id-pe-acmeIdentifier extension matches the digest of keyAuthz, retrying
+// every interval until it appears or total has elapsed.
+func waitForTLSALPNCert(domain, keyAuthz string, total, interval time.Duration) error {
+	expected := sha256.Sum256([]byte(keyAuthz))
+	deadline := time.Now().Add(total)
+
+	for {
+		if digest, err := fetchACMEIdentifierDigest(domain); err == nil && bytes.Equal(digest, expected[:]) {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Errorf("aaa: tls-alpn-01 certificate for %s did not propagate within %s", domain, total)
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// fetchACMEIdentifierDigest dials domain on port 443 negotiating acme-tls/1
+// and returns the digest carried in the served certificate's
+// id-pe-acmeIdentifier extension.
+func fetchACMEIdentifierDigest(domain string) ([]byte, error) {
+	conn, err := tls.Dial("tcp", domain+":443", &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to dial the tls-alpn-01 terminator")
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return nil, errors.New("aaa: terminator presented no certificate")
+	}
+
+	for _, ext := range certs[0].Extensions {
+		if !ext.Id.Equal(idPeACMEIdentifier) {
+			continue
+		}
+
+		var digest []byte
+		if _, err := asn1.Unmarshal(ext.Value, &digest); err != nil {
+			return nil, errors.Wrap(err, "failed to unmarshal the acmeIdentifier extension")
+		}
+		return digest, nil
+	}
+
+	return nil, errors.New("aaa: certificate has no acmeIdentifier extension")
+}
+
+// CleanupChallenge removes the certificate published for this challenge.
+func (s *TLSALPNChallengeSolver) CleanupChallenge(keyAuthz string) error {
+	return s.target.Delete(s.domain)
+}
+
+// buildTLSALPNCertificate generates a self-signed certificate for domain
+// whose id-pe-acmeIdentifier extension carries the SHA-256 digest of
+// keyAuthz, per RFC 8737 section 3.
+func buildTLSALPNCertificate(domain, keyAuthz string) (certPEM, keyPEM []byte, err error) {
+	digest := sha256.Sum256([]byte(keyAuthz))
+
+	acmeIdentifier, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal the acmeIdentifier extension")
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate the challenge certificate key")
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to generate the challenge certificate serial number")
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		ExtraExtensions: []pkix.Extension{
+			{
+				Id:       idPeACMEIdentifier,
+				Critical: true,
+				Value:    acmeIdentifier,
+			},
+		},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &priv.PublicKey, priv)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to create the challenge certificate")
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal the challenge certificate key")
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
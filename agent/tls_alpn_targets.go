@@ -0,0 +1,126 @@
+package agent
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/acm"
+	"github.com/aws/aws-sdk-go/service/iam"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/nabeken/aws-go-s3/bucket"
+	"github.com/pkg/errors"
+)
+
+// iamServerCertTarget uploads the tls-alpn-01 certificate as an IAM server
+// certificate, for use with a Classic/Network Load Balancer listener.
+type iamServerCertTarget struct {
+	svc  *iam.IAM
+	name string
+}
+
+func newIAMServerCertTarget(name string) *iamServerCertTarget {
+	return &iamServerCertTarget{svc: iam.New(session.New()), name: name}
+}
+
+func (t *iamServerCertTarget) Upload(domain string, certPEM, keyPEM []byte) error {
+	_, err := t.svc.UploadServerCertificate(&iam.UploadServerCertificateInput{
+		ServerCertificateName: aws.String(t.name),
+		CertificateBody:       aws.String(string(certPEM)),
+		PrivateKey:            aws.String(string(keyPEM)),
+	})
+	return errors.Wrap(err, "failed to upload the tls-alpn-01 IAM server certificate")
+}
+
+func (t *iamServerCertTarget) Delete(domain string) error {
+	_, err := t.svc.DeleteServerCertificate(&iam.DeleteServerCertificateInput{
+		ServerCertificateName: aws.String(t.name),
+	})
+	return errors.Wrap(err, "failed to delete the tls-alpn-01 IAM server certificate")
+}
+
+// acmTarget imports the tls-alpn-01 certificate into ACM, for use with an
+// ALB/NLB listener backed by an ACM certificate.
+type acmTarget struct {
+	svc         *acm.ACM
+	certificate *string
+}
+
+func newACMTarget(arn string) *acmTarget {
+	t := &acmTarget{svc: acm.New(session.New())}
+	if arn != "" {
+		t.certificate = aws.String(arn)
+	}
+	return t
+}
+
+func (t *acmTarget) Upload(domain string, certPEM, keyPEM []byte) error {
+	resp, err := t.svc.ImportCertificate(&acm.ImportCertificateInput{
+		CertificateArn: t.certificate,
+		Certificate:    certPEM,
+		PrivateKey:     keyPEM,
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to import the tls-alpn-01 certificate into ACM")
+	}
+
+	t.certificate = resp.CertificateArn
+
+	return nil
+}
+
+func (t *acmTarget) Delete(domain string) error {
+	if t.certificate == nil {
+		return nil
+	}
+
+	_, err := t.svc.DeleteCertificate(&acm.DeleteCertificateInput{
+		CertificateArn: t.certificate,
+	})
+	return errors.Wrap(err, "failed to delete the tls-alpn-01 certificate from ACM")
+}
+
+// s3CertTarget uploads the tls-alpn-01 certificate and key to an S3 location
+// consumed by a sidecar that terminates TLS in front of the domain.
+type s3CertTarget struct {
+	bucket *bucket.Bucket
+	prefix string
+}
+
+func newS3CertTarget(location string) (*s3CertTarget, error) {
+	loc := strings.TrimPrefix(location, "s3://")
+
+	parts := strings.SplitN(loc, "/", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return nil, errors.New("aaa: tls-alpn-01 s3 target must be in the form s3://bucket/prefix")
+	}
+
+	return &s3CertTarget{
+		bucket: bucket.New(s3.New(session.New()), parts[0]),
+		prefix: parts[1],
+	}, nil
+}
+
+func (t *s3CertTarget) Upload(domain string, certPEM, keyPEM []byte) error {
+	if _, err := t.bucket.PutObject(t.prefix+"/"+domain+".crt", certPEM, "private", ""); err != nil {
+		return errors.Wrap(err, "failed to upload the tls-alpn-01 certificate to S3")
+	}
+
+	if _, err := t.bucket.PutObject(t.prefix+"/"+domain+".key", keyPEM, "private", ""); err != nil {
+		return errors.Wrap(err, "failed to upload the tls-alpn-01 private key to S3")
+	}
+
+	return nil
+}
+
+func (t *s3CertTarget) Delete(domain string) error {
+	if _, err := t.bucket.DeleteObject(t.prefix + "/" + domain + ".crt"); err != nil {
+		return errors.Wrap(err, "failed to delete the tls-alpn-01 certificate from S3")
+	}
+
+	if _, err := t.bucket.DeleteObject(t.prefix + "/" + domain + ".key"); err != nil {
+		return errors.Wrap(err, "failed to delete the tls-alpn-01 private key from S3")
+	}
+
+	return nil
+}
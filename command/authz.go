@@ -3,39 +3,133 @@ package command
 import (
 	"fmt"
 	"log"
+	"strings"
+	"sync"
 
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hashicorp/go-multierror"
 	"github.com/nabeken/aaa/agent"
 	"github.com/nabeken/aws-go-s3/bucket"
 	"github.com/pkg/errors"
 )
 
+// authzWorkerPoolSize bounds how many SAN authorizations are resolved
+// concurrently by a single AuthzCommand run.
+const authzWorkerPoolSize = 4
+
 type AuthzCommand struct {
-	Domain    string `long:"domain" description:"Domain to be authorized" required:"true"`
-	Challenge string `long:"challenge" description:"Challenge Type" default:"dns-01"`
+	Domains   []string `long:"domain" description:"Domain to be authorized (repeat for multiple SANs)" required:"true"`
+	Challenge string   `long:"challenge" description:"Challenge Type" default:"dns-01"`
+
+	DNSProvider string `long:"dns-provider" description:"DNS provider for dns-01: route53, cloudflare or rfc2136" default:"route53"`
+
+	CloudflareAPIToken string `long:"cloudflare-api-token" env:"CLOUDFLARE_API_TOKEN" description:"Cloudflare API token (dns-provider=cloudflare)"`
+	CloudflareTTL      int    `long:"cloudflare-ttl" env:"CLOUDFLARE_TTL" description:"TTL in seconds for records created in Cloudflare, 0 for automatic" default:"0"`
+
+	RFC2136Nameserver string `long:"rfc2136-nameserver" env:"RFC2136_NAMESERVER" description:"host:port of the nameserver accepting dynamic updates (dns-provider=rfc2136)"`
+	RFC2136TSIGKey    string `long:"rfc2136-tsig-key" env:"RFC2136_TSIG_KEY" description:"TSIG key name"`
+	RFC2136TSIGSecret string `long:"rfc2136-tsig-secret" env:"RFC2136_TSIG_SECRET" description:"TSIG key secret, base64-encoded"`
+	RFC2136TSIGAlgo   string `long:"rfc2136-tsig-algo" env:"RFC2136_TSIG_ALGO" description:"TSIG algorithm" default:"hmac-sha256."`
+
+	HTTPChallengeBucket string `long:"http-challenge-bucket" description:"S3 bucket to publish http-01 challenge responses to, required for --challenge=http-01"`
+	HTTPChallengePrefix string `long:"http-challenge-prefix" description:"Key prefix under which .well-known/acme-challenge is published"`
+
+	TLSALPNTarget     string `long:"tls-alpn-target" description:"Where to publish the tls-alpn-01 certificate: iam, acm or s3" default:"s3"`
+	TLSALPNTargetName string `long:"tls-alpn-target-name" description:"IAM server certificate name, ACM ARN, or s3://bucket/prefix depending on --tls-alpn-target"`
+
+	EABKeyID   string `long:"eab-kid" env:"ACME_EAB_KID" description:"External Account Binding key ID issued by the CA"`
+	EABHMACKey string `long:"eab-hmac-key" env:"ACME_EAB_HMAC_KEY" description:"External Account Binding HMAC key issued by the CA, base64url-encoded"`
 }
 
 func (c *AuthzCommand) Execute(args []string) error {
+	// HTTPChallengeBucket is deliberately not defaulted to Options.S3Bucket:
+	// that bucket also holds the ACME account's private key, and the
+	// http-01 solver PutObjects into it with a public-read ACL, so silently
+	// reusing it would make a credential-bearing bucket world-readable.
+	if c.Challenge == "http-01" && c.HTTPChallengeBucket == "" {
+		return errors.New("aaa: --http-challenge-bucket is required for --challenge=http-01")
+	}
+
 	return (&AuthzService{
-		Domain:     c.Domain,
-		Challenge:  c.Challenge,
-		S3Bucket:   Options.S3Bucket,
-		S3KMSKeyID: Options.S3KMSKeyID,
-		Email:      Options.Email,
+		Domains:             c.Domains,
+		Challenge:           c.Challenge,
+		S3Bucket:            Options.S3Bucket,
+		S3KMSKeyID:          Options.S3KMSKeyID,
+		Email:               Options.Email,
+		DNSProvider:         c.DNSProvider,
+		CloudflareAPIToken:  c.CloudflareAPIToken,
+		CloudflareTTL:       c.CloudflareTTL,
+		RFC2136Nameserver:   c.RFC2136Nameserver,
+		RFC2136TSIGKey:      c.RFC2136TSIGKey,
+		RFC2136TSIGSecret:   c.RFC2136TSIGSecret,
+		RFC2136TSIGAlgo:     c.RFC2136TSIGAlgo,
+		HTTPChallengeBucket: c.HTTPChallengeBucket,
+		HTTPChallengePrefix: c.HTTPChallengePrefix,
+		TLSALPNTarget:       c.TLSALPNTarget,
+		TLSALPNTargetName:   c.TLSALPNTargetName,
+		EABKeyID:            c.EABKeyID,
+		EABHMACKey:          c.EABHMACKey,
 	}).Run()
 }
 
 type AuthzService struct {
-	Domain     string
+	// Domains is the set of identifiers (SANs) to authorize in a single
+	// order. A domain beginning with "*." is a wildcard identifier: it is
+	// always solved via dns-01, since DNS-01 is the only challenge type
+	// ACME allows for wildcards.
+	Domains    []string
 	Challenge  string
 	S3Bucket   string
 	S3KMSKeyID string
 	Email      string
+
+	// DNSProvider selects the agent.DNSProvider implementation used to
+	// solve dns-01: "route53", "cloudflare" or "rfc2136". The
+	// Cloudflare*/RFC2136* fields below configure the respective
+	// providers.
+	DNSProvider string
+
+	CloudflareAPIToken string
+	CloudflareTTL      int
+
+	RFC2136Nameserver string
+	RFC2136TSIGKey    string
+	RFC2136TSIGSecret string
+	RFC2136TSIGAlgo   string
+
+	// HTTPChallengeBucket and HTTPChallengePrefix configure where the
+	// http-01 solver publishes the key authorization. HTTPChallengeBucket
+	// may point at a different (e.g. public, CloudFront-fronted) bucket
+	// than the one backing the store.
+	HTTPChallengeBucket string
+	HTTPChallengePrefix string
+
+	// TLSALPNTarget and TLSALPNTargetName configure where the tls-alpn-01
+	// solver publishes the challenge certificate: an IAM server
+	// certificate, an ACM import, or an S3 location consumed by a
+	// sidecar.
+	//
+	// KNOWN LIMITATION: "iam" and "acm" both publish under a single
+	// name/ARN shared by every domain in the run, so two workers racing to
+	// Upload different domains' certificates into that same identity would
+	// clobber each other mid-validation. See workerPoolSize.
+	TLSALPNTarget     string
+	TLSALPNTargetName string
+
+	// EABKeyID and EABHMACKey bind the ACME account to an externally
+	// issued key, required by CAs such as ZeroSSL, Google Trust Services
+	// and most enterprise step-ca deployments.
+	EABKeyID   string
+	EABHMACKey string
 }
 
 func (svc *AuthzService) Run() error {
+	if err := svc.validateDomains(); err != nil {
+		return err
+	}
+
 	// initialize S3 bucket and filer
 	s3b := bucket.New(s3.New(session.New()), svc.S3Bucket)
 	filer := agent.NewS3Filer(s3b, svc.S3KMSKeyID)
@@ -44,85 +138,325 @@ func (svc *AuthzService) Run() error {
 		return errors.Wrap(err, "failed to initialize the store")
 	}
 
-	log.Printf("INFO: start authorization for %s with %s", svc.Domain, svc.Challenge)
+	log.Printf("INFO: start authorization for %s with %s", strings.Join(svc.Domains, ", "), svc.Challenge)
 
-	newAuthzReq := &agent.NewAuthorizationRequest{
-		Identifier: &agent.Identifier{
-			Type:  "dns",
-			Value: svc.Domain,
-		},
+	identifiers := make([]*agent.Identifier, len(svc.Domains))
+	for i, domain := range svc.Domains {
+		identifiers[i] = &agent.Identifier{Type: "dns", Value: domain}
 	}
 
-	// initialize client here
-	client := agent.NewClient(DirectoryURL(), store)
-	if err := client.Init(); err != nil {
-		return errors.Wrap(err, "failed to initialize the client")
+	newOrderReq := &agent.NewOrderRequest{Identifiers: identifiers}
+
+	client, err := svc.newClient(store)
+	if err != nil {
+		return err
+	}
+
+	order, err := client.NewOrder(newOrderReq)
+	if err != nil {
+		return errors.Wrap(err, "order is failed")
+	}
+
+	if len(order.Authorizations) != len(svc.Domains) {
+		return errors.New("aaa: order returned a different number of authorizations than requested SANs")
+	}
+
+	log.Printf("INFO: order created with %d authorization(s)", len(order.Authorizations))
+
+	type job struct {
+		url string
+	}
+
+	// jobs is sized to hold every authorization up front so the producer
+	// below never blocks on a worker to drain it -- if every worker fails
+	// to initialize (e.g. a bad EAB key or a CA directory blip) and none of
+	// them ever reaches the receive loop, filling an unbuffered channel
+	// would hang Run() forever instead of returning the errs already
+	// queued.
+	jobs := make(chan job, len(order.Authorizations))
+	results := make(chan *agent.AuthorizationResponse, len(order.Authorizations))
+	// errs has room for one error per authorization plus one init failure
+	// per worker, so no worker ever blocks sending to it.
+	errs := make(chan error, len(order.Authorizations)+svc.workerPoolSize())
+
+	var wg sync.WaitGroup
+	for i := 0; i < svc.workerPoolSize(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each worker gets its own agent.Client: the replay nonce a
+			// client holds is single-use and consumed/replaced by every
+			// ACME POST, so sharing one client across concurrent workers
+			// races the nonce and trips the CA's badNonce check.
+			workerClient, err := svc.newClient(store)
+			if err != nil {
+				errs <- errors.Wrap(err, "failed to initialize a worker client")
+				return
+			}
+
+			for j := range jobs {
+				currentAuthz, err := svc.solveAuthorization(workerClient, store, j.url)
+				if err != nil {
+					errs <- errors.Wrapf(err, "failed to authorize %s", j.url)
+					continue
+				}
+				results <- currentAuthz
+			}
+		}()
+	}
+
+	for _, url := range order.Authorizations {
+		jobs <- job{url: url}
+	}
+	close(jobs)
+
+	wg.Wait()
+	close(results)
+	close(errs)
+
+	var result *multierror.Error
+	for err := range errs {
+		result = multierror.Append(result, err)
+	}
+
+	if err := result.ErrorOrNil(); err != nil {
+		return err
+	}
+
+	// only persist the order once every SAN's authorization has reached
+	// "valid" -- a partially solved order is not useful and shouldn't be
+	// recorded as if it were.
+	for currentAuthz := range results {
+		if err := store.SaveAuthorization(currentAuthz); err != nil {
+			return errors.Wrap(err, "failed to save the authorization in the store")
+		}
 	}
 
-	authzResp, err := client.NewAuthorization(newAuthzReq)
+	log.Print("INFO: all authorizations have been solved")
+
+	return nil
+}
+
+// solveAuthorization resolves a single SAN's authorization at authzURL,
+// forcing dns-01 for wildcard domains since DNS-01 is the only challenge
+// type ACME permits for them.
+//
+// The domain being authorized is read back from authzResp's own identifier
+// rather than zipped positionally against svc.Domains: RFC 8555 doesn't
+// guarantee a CA returns order.Authorizations in the same order as the
+// identifiers sent in the newOrder request, and getting this wrong would
+// solve the challenge for the wrong name.
+func (svc *AuthzService) solveAuthorization(client *agent.Client, store *agent.Store, authzURL string) (*agent.AuthorizationResponse, error) {
+	authzResp, err := client.GetAuthorization(authzURL)
 	if err != nil {
-		return errors.Wrap(err, "authorization is failed")
+		return nil, errors.Wrap(err, "failed to get the authorization")
+	}
+
+	domain := authzResp.Identifier.Value
+	if authzResp.Wildcard {
+		domain = "*." + domain
 	}
 
-	log.Printf("INFO: authorization: %s", authzResp.URL)
+	log.Printf("INFO: authorization: %s (%s)", authzResp.URL, domain)
+
+	challengeType := svc.Challenge
+
+	apexDomain := domain
+	if strings.HasPrefix(domain, "*.") {
+		// DNS-01 for *.example.com requires a TXT record on
+		// _acme-challenge.example.com, not on the wildcard label itself.
+		//
+		// KNOWN LIMITATION: when Domains contains both "example.com" and
+		// "*.example.com", both authorizations solve dns-01 against this
+		// same _acme-challenge.example.com name, each with its own
+		// challenge value, so the two need to coexist as two TXT records
+		// under one name. Cloudflare (additive create) and RFC 2136
+		// (additive insert) tolerate this; a DNSProvider built on Route53
+		// UPSERT does not, since UPSERT replaces the whole recordset and
+		// would clobber the sibling authorization's value. Until such a
+		// provider tracks and merges co-located values, don't combine an
+		// apex domain with its wildcard in the same --dns-provider=
+		// route53 run.
+		apexDomain = strings.TrimPrefix(domain, "*.")
+		challengeType = "dns-01"
+	}
 
 	var challenge agent.Challenge
 	var challengeSolver agent.ChallengeSolver
 
-	switch svc.Challenge {
+	switch challengeType {
 	case "dns-01":
 		dnsChallenge, found := agent.FindDNSChallenge(authzResp)
 		if !found {
-			return errors.New("aaa: no DNS challenge and its combination found")
+			return nil, errors.New("aaa: no DNS challenge and its combination found")
+		}
+
+		dnsProvider, err := svc.newDNSProvider()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to initialize the DNS provider")
 		}
 
-		r53 := agent.NewRoute53Provider(route53.New(session.New()))
 		challenge = dnsChallenge
-		challengeSolver = agent.NewDNSChallengeSolver(r53, dnsChallenge, svc.Domain)
+		challengeSolver = agent.NewDNSChallengeSolver(dnsProvider, apexDomain)
+	case "http-01":
+		httpChallenge, found := agent.FindHTTPChallenge(authzResp)
+		if !found {
+			return nil, errors.New("aaa: no HTTP challenge and its combination found")
+		}
+
+		httpBucket := bucket.New(s3.New(session.New()), svc.HTTPChallengeBucket)
+		challenge = httpChallenge
+		challengeSolver = agent.NewHTTPChallengeSolver(httpBucket, svc.HTTPChallengePrefix, httpChallenge)
+	case "tls-alpn-01":
+		tlsALPNChallenge, found := agent.FindTLSALPNChallenge(authzResp)
+		if !found {
+			return nil, errors.New("aaa: no TLS-ALPN challenge and its combination found")
+		}
+
+		target, err := agent.NewTLSALPNCertTarget(svc.TLSALPNTarget, svc.TLSALPNTargetName)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to initialize the tls-alpn-01 target")
+		}
+
+		challenge = tlsALPNChallenge
+		challengeSolver = agent.NewTLSALPNChallengeSolver(target, domain, tlsALPNChallenge)
 	default:
-		return fmt.Errorf("aaa: challenge %s is not supported")
+		return nil, fmt.Errorf("aaa: challenge %s is not supported", challengeType)
 	}
 
 	publicKey, err := store.LoadPublicKey()
 	if err != nil {
-		return errors.Wrap(err, "failed to load the public key")
+		return nil, errors.Wrap(err, "failed to load the public key")
 	}
 
 	keyAuthz, err := agent.BuildKeyAuthorization(challenge.Token, publicKey)
 	if err != nil {
-		return errors.Wrap(err, "failed to build authorizatio key")
+		return nil, errors.Wrap(err, "failed to build authorizatio key")
 	}
 
 	agent.Debug("KeyAuthorization: ", keyAuthz)
 
 	if err := challengeSolver.SolveChallenge(keyAuthz); err != nil {
-		return errors.Wrap(err, "failed to solve the challenge")
+		return nil, errors.Wrap(err, "failed to solve the challenge")
 	}
 
 	if err := client.SolveChallenge(challenge, keyAuthz); err != nil {
-		return errors.Wrap(err, "failed to submit the solution")
+		return nil, errors.Wrap(err, "failed to submit the solution")
 	}
 
 	if err := client.WaitChallengeDone(challenge); err != nil {
-		log.Print("INFO: challenge has been failed")
-		return errors.Wrap(err, "failed to do challenge")
+		log.Printf("INFO: challenge has been failed for %s", domain)
+		return nil, errors.Wrap(err, "failed to do challenge")
 	}
 
 	if err := challengeSolver.CleanupChallenge(keyAuthz); err != nil {
-		return errors.Wrap(err, "failed to cleanup the challenge")
+		return nil, errors.Wrap(err, "failed to cleanup the challenge")
 	}
 
 	// getting the latest authorization status
 	currentAuthz, err := client.GetAuthorization(authzResp.URL)
 	if err != nil {
-		return errors.Wrap(err, "failed to get authorization")
+		return nil, errors.Wrap(err, "failed to get authorization")
 	}
 
-	if err := store.SaveAuthorization(currentAuthz); err != nil {
-		return errors.Wrap(err, "failed to save the authorization in the store")
+	return currentAuthz, nil
+}
+
+// newClient builds and initializes an agent.Client bound to store,
+// configured with External Account Binding when svc.EABKeyID is set. Each
+// call returns an independent client with its own replay nonce, so callers
+// that solve authorizations concurrently must call this once per worker
+// rather than share a single client.
+func (svc *AuthzService) newClient(store *agent.Store) (*agent.Client, error) {
+	var clientOpts []agent.ClientOption
+	if svc.EABKeyID != "" {
+		hmacKey, err := agent.DecodeEABHMACKey(svc.EABHMACKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to decode the EAB HMAC key")
+		}
+		clientOpts = append(clientOpts, agent.WithExternalAccountBinding(svc.EABKeyID, hmacKey))
 	}
 
-	log.Print("INFO: challenge has been solved")
+	client := agent.NewClient(DirectoryURL(), store, clientOpts...)
+	if err := client.Init(); err != nil {
+		return nil, errors.Wrap(err, "failed to initialize the client")
+	}
+
+	return client, nil
+}
+
+// workerPoolSize returns how many SAN authorizations Run should resolve
+// concurrently. tls-alpn-01 against the "iam" or "acm" target publishes the
+// challenge certificate under a single IAM server-certificate name / ACM
+// ARN shared by every domain in the run, so that combination is forced onto
+// the serial path instead of the usual pool -- see the KNOWN LIMITATION note
+// on TLSALPNTarget.
+func (svc *AuthzService) workerPoolSize() int {
+	if svc.Challenge == "tls-alpn-01" && (svc.TLSALPNTarget == "iam" || svc.TLSALPNTarget == "acm") {
+		return 1
+	}
+	return authzWorkerPoolSize
+}
+
+// validateDomains rejects domain combinations this AuthzService cannot
+// safely solve. An apex and its wildcard ("example.com" and
+// "*.example.com") both solve dns-01 against the same
+// _acme-challenge.example.com name -- see the KNOWN LIMITATION note in
+// solveAuthorization -- and Route53's UPSERT-based Present/CleanUp
+// replaces the whole recordset rather than letting the two coexist, so one
+// authorization's TXT value clobbers the other's and one SAN fails
+// validation. Apex+wildcard is the single most common cert shape, so this
+// is checked upfront rather than left to surface as a confusing failure
+// mid-run.
+func (svc *AuthzService) validateDomains() error {
+	if svc.DNSProvider != "" && svc.DNSProvider != "route53" {
+		return nil
+	}
+
+	apex := make(map[string]bool)
+	wildcard := make(map[string]bool)
+	for _, domain := range svc.Domains {
+		if strings.HasPrefix(domain, "*.") {
+			wildcard[strings.TrimPrefix(domain, "*.")] = true
+			continue
+		}
+
+		// Only dns-01 apex authorizations collide with their wildcard's
+		// TXT record; an apex solved via http-01/tls-alpn-01 doesn't touch
+		// _acme-challenge at all.
+		if svc.Challenge == "dns-01" {
+			apex[domain] = true
+		}
+	}
+
+	for domain := range wildcard {
+		if apex[domain] {
+			return errors.Errorf("aaa: %s and *.%s cannot both be solved via dns-01 on dns-provider=route53: UPSERT would clobber one's TXT value with the other's", domain, domain)
+		}
+	}
 
 	return nil
 }
+
+// newDNSProvider builds the agent.DNSProvider selected by svc.DNSProvider.
+func (svc *AuthzService) newDNSProvider() (agent.DNSProvider, error) {
+	switch svc.DNSProvider {
+	case "", "route53":
+		// See the KNOWN LIMITATION note in solveAuthorization: Route53's
+		// UPSERT-based Present/CleanUp can't yet coexist with a sibling
+		// apex+wildcard authorization sharing the same TXT name.
+		return agent.NewRoute53Provider(route53.New(session.New())), nil
+	case "cloudflare":
+		return agent.NewCloudflareProvider(svc.CloudflareAPIToken, svc.CloudflareTTL)
+	case "rfc2136":
+		return agent.NewRFC2136Provider(
+			svc.RFC2136Nameserver,
+			svc.RFC2136TSIGKey,
+			svc.RFC2136TSIGSecret,
+			svc.RFC2136TSIGAlgo,
+		), nil
+	default:
+		return nil, fmt.Errorf("aaa: dns provider %s is not supported", svc.DNSProvider)
+	}
+}
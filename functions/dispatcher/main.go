@@ -1,6 +1,8 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"os"
@@ -9,49 +11,168 @@ import (
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/lambda"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/nabeken/aaa/queue"
 	"github.com/nabeken/aaa/slack"
 	"github.com/pkg/errors"
 )
 
-var lambdaSvc *lambda.Lambda
+// dispatchModeInvoke calls the executor synchronously via Lambda Invoke,
+// the historical behavior. dispatchModeSQS, selected by
+// AAA_DISPATCH_MODE=sqs, names dispatch through a FIFO queue (see enqueue);
+// realmain rejects that mode with an error, so every accepted command goes
+// through Invoke.
+const (
+	dispatchModeInvoke = "invoke"
+	dispatchModeSQS    = "sqs"
+)
+
+var (
+	lambdaSvc *lambda.Lambda
+	sqsSvc    *sqs.SQS
+)
 
 func realmain(event json.RawMessage) (*slack.CommandResponse, error) {
-	token := os.Getenv("SLACK_TOKEN")
+	signingSecret := os.Getenv("SLACK_SIGNING_SECRET")
 	executorFuncName := os.Getenv("AAA_EXECUTOR_FUNC_NAME")
+	dispatchMode := os.Getenv("AAA_DISPATCH_MODE")
+	sqsQueueURL := os.Getenv("AAA_SQS_QUEUE_URL")
 
 	if executorFuncName == "" {
 		return nil, errors.New("Please set AAA_EXECUTOR_FUNC_NAME environment variable.")
 	}
 
+	if dispatchMode == "" {
+		dispatchMode = dispatchModeInvoke
+	}
+
+	if dispatchMode == dispatchModeSQS && sqsQueueURL == "" {
+		return nil, errors.New("Please set AAA_SQS_QUEUE_URL environment variable.")
+	}
+
+	// TODO(executor): the executor Lambda lives outside this module and
+	// still parses its input as if it were always a direct Invoke payload.
+	// Until its entrypoint calls queue.UnwrapSQSEvent, refuse the mode
+	// outright instead of enqueueing messages it cannot consume.
+	if dispatchMode == dispatchModeSQS {
+		return nil, errors.New("AAA_DISPATCH_MODE=sqs is not yet supported: the executor cannot consume SQS-wrapped events")
+	}
+
+	if err := slack.VerifySigningSecret(event, signingSecret); err != nil {
+		return nil, errors.Wrap(err, "failed to verify the request signature")
+	}
+
+	if slack.IsInteraction(event) {
+		return handleInteraction(event)
+	}
+
 	slcmd, err := slack.ParseCommand(event)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse the command")
 	}
 
-	if slcmd.Token != token {
-		return nil, errors.New("Who are you? Token does not match.")
+	runID := idempotencyToken(slcmd)
+
+	switch dispatchMode {
+	case dispatchModeSQS:
+		if err := enqueue(sqsQueueURL, event, slcmd); err != nil {
+			return nil, errors.Wrap(err, "failed to enqueue the command")
+		}
+	default:
+		if err := invoke(executorFuncName, event); err != nil {
+			return nil, errors.Wrap(err, "failed to invoke the executor")
+		}
+	}
+
+	resp := &slack.CommandResponse{
+		ResponseType: "in_channel",
+		Text:         fmt.Sprintf("%s Your request has been accepted.", slack.FormatUserName(slcmd.UserName)),
+		Blocks:       slack.StatusBlocks(slcmd.Domain(), slcmd.Challenge(), runID),
+	}
+
+	return resp, nil
+}
+
+// handleInteraction responds to a Block Kit button click. No status message
+// renders a button yet -- see slack.CancelActionID -- so in practice this is
+// only reachable from a stale client replaying an old interaction payload.
+func handleInteraction(event json.RawMessage) (*slack.CommandResponse, error) {
+	in, err := slack.ParseInteraction(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the interaction")
+	}
+
+	switch in.ActionID {
+	case slack.CancelActionID:
+		// TODO(executor): cancelRun would drop a sentinel object for the
+		// executor's ACME wait loop to poll for, but nothing reads it yet.
+		// Refuse outright rather than telling the user their cancellation
+		// took effect when it didn't.
+		return nil, errors.New("aaa: cancelling a run is not yet supported")
+	default:
+		return nil, errors.Errorf("aaa: unknown interaction action_id %s", in.ActionID)
 	}
+}
 
+// invoke dispatches event to the executor directly. This couples Slack's
+// 3-second ACK budget to Lambda control-plane latency and loses the job on
+// throttling; prefer enqueue where reliability matters more than latency.
+func invoke(executorFuncName string, event json.RawMessage) error {
 	req := &lambda.InvokeInput{
 		FunctionName:   aws.String(executorFuncName),
 		InvocationType: aws.String(lambda.InvocationTypeEvent),
 		Payload:        event,
 	}
 
-	if _, err := lambdaSvc.Invoke(req); err != nil {
-		return nil, errors.Wrap(err, "failed to invoke the executor")
+	_, err := lambdaSvc.Invoke(req)
+	return err
+}
+
+// enqueue places the command onto the SQS FIFO queue backing the executor's
+// event-source mapping. The message group is keyed by domain so that
+// concurrent authz/cert runs for the same domain are serialized by SQS
+// rather than racing in two executor invocations. The visibility timeout on
+// the queue should be sized to comfortably exceed the longest ACME wait
+// loop (WaitChallengeDone polling, typically a few minutes) so the executor
+// is never handed a duplicate delivery mid-run; a DLQ with a small
+// maxReceiveCount should be attached so a command that keeps failing the
+// executor doesn't retry forever.
+func enqueue(queueURL string, event json.RawMessage, slcmd *slack.Command) error {
+	msg := &queue.Message{
+		Event:            event,
+		IdempotencyToken: idempotencyToken(slcmd),
+		GroupKey:         slcmd.Domain(),
 	}
 
-	resp := &slack.CommandResponse{
-		ResponseType: "in_channel",
-		Text:         fmt.Sprintf("%s Your request has been accepted.", slack.FormatUserName(slcmd.UserName)),
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal the queue message")
 	}
 
-	return resp, nil
+	_, err = sqsSvc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:               aws.String(queueURL),
+		MessageBody:            aws.String(string(body)),
+		MessageGroupId:         aws.String(msg.GroupKey),
+		MessageDeduplicationId: aws.String(msg.IdempotencyToken),
+	})
+	return err
+}
+
+// idempotencyToken derives a stable SQS MessageDeduplicationId from the
+// Slack trigger_id, so that sending the same slash command invocation to
+// the FIFO queue more than once within SQS's deduplication interval
+// enqueues it only once. It does not stop SQS from redelivering a message
+// that the executor failed to process.
+func idempotencyToken(slcmd *slack.Command) string {
+	sum := sha256.Sum256([]byte(slcmd.TriggerID))
+	return hex.EncodeToString(sum[:])
 }
 
 func main() {
-	lambdaSvc = lambda.New(session.Must(session.NewSession()))
+	sess := session.Must(session.NewSession())
+
+	lambdaSvc = lambda.New(sess)
+	sqsSvc = sqs.New(sess)
 
 	golambda.Start(realmain)
 }
@@ -0,0 +1,66 @@
+// Package queue defines the message format shared between the Slack
+// front-end dispatcher and the executor Lambda when dispatch is done
+// through SQS instead of a direct Invoke.
+package queue
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// Message is the body of the SQS message enqueued by the dispatcher and
+// consumed by the executor's SQS event-source mapping. It wraps the raw
+// Lambda event the executor would otherwise have received directly; the
+// executor must call UnwrapSQSEvent to get back to it, since an SQS
+// event-source mapping hands the executor an SQS event, not the Message
+// itself.
+type Message struct {
+	Event json.RawMessage `json:"event"`
+
+	// IdempotencyToken is derived from the Slack request's trigger_id so
+	// that an SQS-driven retry of the same slash command invocation does
+	// not result in a duplicate authz/issuance run.
+	IdempotencyToken string `json:"idempotency_token"`
+
+	// GroupKey is used as the SQS FIFO MessageGroupId so that two runs for
+	// the same domain are never processed concurrently.
+	GroupKey string `json:"group_key"`
+}
+
+// sqsEvent mirrors the subset of the Lambda SQS event source payload
+// UnwrapSQSEvent needs. A real SQSEvent carries more fields
+// (github.com/aws/aws-lambda-go/events.SQSEvent); they're omitted here since
+// nothing downstream reads them.
+type sqsEvent struct {
+	Records []struct {
+		Body string `json:"body"`
+	} `json:"Records"`
+}
+
+// UnwrapSQSEvent extracts the queue.Message from the body of the first
+// record of an SQS event-source event. The executor's entrypoint must call
+// this (instead of parsing rawEvent directly) when AAA_DISPATCH_MODE=sqs,
+// since the event it receives is the SQS event wrapping the Message, not
+// the Message's Event field directly.
+//
+// The executor Lambda lives outside this module and does not call
+// UnwrapSQSEvent; the dispatcher rejects AAA_DISPATCH_MODE=sqs, so no
+// Message is enqueued.
+func UnwrapSQSEvent(rawEvent json.RawMessage) (*Message, error) {
+	var event sqsEvent
+	if err := json.Unmarshal(rawEvent, &event); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the SQS event")
+	}
+
+	if len(event.Records) == 0 {
+		return nil, errors.New("aaa: SQS event has no records")
+	}
+
+	var msg Message
+	if err := json.Unmarshal([]byte(event.Records[0].Body), &msg); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal the queue message")
+	}
+
+	return &msg, nil
+}
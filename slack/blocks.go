@@ -0,0 +1,48 @@
+package slack
+
+// Block is a single Block Kit layout block. Only the subset used to render
+// authz/cert status is implemented.
+type Block struct {
+	Type     string      `json:"type"`
+	Text     *TextObject `json:"text,omitempty"`
+	Elements []Element   `json:"elements,omitempty"`
+	BlockID  string      `json:"block_id,omitempty"`
+}
+
+// TextObject is a Block Kit text composition object.
+type TextObject struct {
+	Type string `json:"type"` // "plain_text" or "mrkdwn"
+	Text string `json:"text"`
+}
+
+// Element is a Block Kit interactive element, e.g. a button.
+type Element struct {
+	Type     string      `json:"type"`
+	Text     *TextObject `json:"text,omitempty"`
+	ActionID string      `json:"action_id,omitempty"`
+	Value    string      `json:"value,omitempty"`
+	Style    string      `json:"style,omitempty"`
+}
+
+// CancelActionID is the action_id the dispatcher's handleInteraction routes a "Cancel"
+// button click to. No status message renders that button yet: the ACME
+// wait loop (agent.Client.WaitChallengeDone) has no cancellation awareness,
+// so there is nothing for a click to actually abort. Keep the constant so
+// the interaction plumbing and its id are already in place once that lands.
+const CancelActionID = "aaa_cancel"
+
+// StatusBlocks renders the Block Kit message shown while an authz/cert run
+// for domain is in flight. runID is accepted so callers don't need to
+// special-case this function when cancellation support lands, but it is
+// currently unused: see CancelActionID.
+func StatusBlocks(domain, challenge, runID string) []Block {
+	return []Block{
+		{
+			Type: "section",
+			Text: &TextObject{
+				Type: "mrkdwn",
+				Text: "Authorizing *" + domain + "* via `" + challenge + "`…",
+			},
+		},
+	}
+}
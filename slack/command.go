@@ -0,0 +1,74 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Command is a parsed Slack slash command invocation, e.g.
+// "/aaa authz example.com dns-01".
+type Command struct {
+	Token       string
+	TeamID      string
+	ChannelID   string
+	UserName    string
+	Text        string
+	TriggerID   string
+	ResponseURL string
+}
+
+// ParseCommand decodes a slash command from an API Gateway proxy event
+// whose body is the application/x-www-form-urlencoded payload Slack sends.
+func ParseCommand(event json.RawMessage) (*Command, error) {
+	var req struct {
+		Body string `json:"body"`
+	}
+
+	if err := json.Unmarshal(event, &req); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the proxy request")
+	}
+
+	form, err := url.ParseQuery(req.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the command body")
+	}
+
+	return &Command{
+		Token:       form.Get("token"),
+		TeamID:      form.Get("team_id"),
+		ChannelID:   form.Get("channel_id"),
+		UserName:    form.Get("user_name"),
+		Text:        form.Get("text"),
+		TriggerID:   form.Get("trigger_id"),
+		ResponseURL: form.Get("response_url"),
+	}, nil
+}
+
+// Domain returns the domain argument out of the command text, which is
+// expected to be in the form "<subcommand> <domain> [challenge]".
+func (c *Command) Domain() string {
+	fields := strings.Fields(c.Text)
+	if len(fields) < 2 {
+		return ""
+	}
+	return fields[1]
+}
+
+// Challenge returns the optional challenge type argument out of the
+// command text, defaulting to "dns-01" when omitted.
+func (c *Command) Challenge() string {
+	fields := strings.Fields(c.Text)
+	if len(fields) < 3 {
+		return "dns-01"
+	}
+	return fields[2]
+}
+
+// FormatUserName formats userName the way aaa addresses users in its
+// responses.
+func FormatUserName(userName string) string {
+	return "@" + userName
+}
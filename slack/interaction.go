@@ -0,0 +1,81 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/url"
+
+	"github.com/pkg/errors"
+)
+
+// Interaction is a parsed Block Kit block_actions payload, e.g. a user
+// clicking the "Cancel" button on a status message.
+type Interaction struct {
+	Token    string
+	UserName string
+	ActionID string
+	Value    string
+}
+
+// ParseInteraction decodes a block_actions interactive payload from an API
+// Gateway proxy event. Slack POSTs these as a single "payload" form field
+// containing JSON, rather than the flat form encoding slash commands use.
+func ParseInteraction(event json.RawMessage) (*Interaction, error) {
+	var req struct {
+		Body string `json:"body"`
+	}
+
+	if err := json.Unmarshal(event, &req); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the proxy request")
+	}
+
+	form, err := url.ParseQuery(req.Body)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse the interaction body")
+	}
+
+	var payload struct {
+		Token string `json:"token"`
+		User  struct {
+			UserName string `json:"username"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+	}
+
+	if err := json.Unmarshal([]byte(form.Get("payload")), &payload); err != nil {
+		return nil, errors.Wrap(err, "failed to parse the interaction payload")
+	}
+
+	in := &Interaction{
+		Token:    payload.Token,
+		UserName: payload.User.UserName,
+	}
+
+	if len(payload.Actions) > 0 {
+		in.ActionID = payload.Actions[0].ActionID
+		in.Value = payload.Actions[0].Value
+	}
+
+	return in, nil
+}
+
+// IsInteraction reports whether event looks like a Block Kit interactive
+// payload (a "payload" form field) rather than a plain slash command.
+func IsInteraction(event json.RawMessage) bool {
+	var req struct {
+		Body string `json:"body"`
+	}
+
+	if err := json.Unmarshal(event, &req); err != nil {
+		return false
+	}
+
+	form, err := url.ParseQuery(req.Body)
+	if err != nil {
+		return false
+	}
+
+	return form.Get("payload") != ""
+}
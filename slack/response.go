@@ -0,0 +1,9 @@
+package slack
+
+// CommandResponse is the JSON body returned to Slack in answer to a slash
+// command invocation.
+type CommandResponse struct {
+	ResponseType string  `json:"response_type"`
+	Text         string  `json:"text"`
+	Blocks       []Block `json:"blocks,omitempty"`
+}